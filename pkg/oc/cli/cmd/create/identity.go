@@ -0,0 +1,186 @@
+package create
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/kubectl/cmd/templates"
+	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+	kcmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+
+	userapi "github.com/openshift/origin/pkg/user/apis/user"
+	userclientinternal "github.com/openshift/origin/pkg/user/generated/internalclientset"
+	userclient "github.com/openshift/origin/pkg/user/generated/internalclientset/typed/user/internalversion"
+)
+
+const IdentityRecommendedName = "identity"
+
+var (
+	identityLong = templates.LongDesc(`
+		This command can be used to create an identity object that represents a user
+		from an authentication provider (e.g. an entry in an LDAP directory) without
+		requiring that user to log in first. This is useful when the "lookup" mapping
+		method is used and a useridentitymapping needs to reference an identity that
+		does not exist yet.`)
+
+	identityExample = templates.Examples(`
+		# Create an identity for the LDAP authentication provider
+  	%[1]s ldap_provider:adamjones`)
+)
+
+// identityName returns the name an Identity object must have for the given
+// provider name and provider user name, matching the "provider:providerUserName"
+// form used to reference identities elsewhere (e.g. in useridentitymapping).
+func identityName(providerName, providerUserName string) string {
+	return providerName + ":" + providerUserName
+}
+
+type CreateIdentityOptions struct {
+	ProviderName     string
+	ProviderUserName string
+	Extra            map[string]string
+
+	IdentityClient userclient.IdentityInterface
+
+	DryRun bool
+
+	OutputFormat string
+	Out          io.Writer
+	Printer      ObjectPrinter
+}
+
+// NewCmdCreateIdentity is a macro command to create a new identity
+func NewCmdCreateIdentity(name, fullName string, f kcmdutil.Factory, out io.Writer) *cobra.Command {
+	o := &CreateIdentityOptions{Out: out}
+
+	var extra []string
+
+	cmd := &cobra.Command{
+		Use:     name + " <PROVIDER_NAME>:<PROVIDER_USER_NAME>",
+		Short:   "Manually create an identity.",
+		Long:    identityLong,
+		Example: fmt.Sprintf(identityExample, fullName),
+		Run: func(cmd *cobra.Command, args []string) {
+			cmdutil.CheckErr(o.Complete(cmd, f, args, extra))
+			cmdutil.CheckErr(o.Validate())
+			cmdutil.CheckErr(o.Run())
+		},
+	}
+	cmdutil.AddPrinterFlags(cmd)
+	cmdutil.AddDryRunFlag(cmd)
+	cmd.Flags().StringArrayVar(&extra, "extra", nil, "Add an extra key=value pair to the identity's extra data; may be repeated")
+	return cmd
+}
+
+func (o *CreateIdentityOptions) Complete(cmd *cobra.Command, f kcmdutil.Factory, args []string, extra []string) error {
+	switch len(args) {
+	case 0:
+		return fmt.Errorf("provider and provider user name, separated by a colon, are required")
+	case 1:
+		parts := strings.SplitN(args[0], ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return fmt.Errorf("identity must be specified in the form <provider_name>:<provider_user_name>, not %q", args[0])
+		}
+		o.ProviderName, o.ProviderUserName = parts[0], parts[1]
+	default:
+		return fmt.Errorf("exactly one argument (<provider_name>:<provider_user_name>) is supported, not: %v", args)
+	}
+
+	extraMap, err := parseExtraFlags(extra)
+	if err != nil {
+		return err
+	}
+	o.Extra = extraMap
+
+	o.DryRun = cmdutil.GetFlagBool(cmd, "dry-run")
+
+	if !o.DryRun {
+		clientConfig, err := f.ToRESTConfig()
+		if err != nil {
+			return err
+		}
+		client, err := userclientinternal.NewForConfig(clientConfig)
+		if err != nil {
+			return err
+		}
+		o.IdentityClient = client.User().Identities()
+	}
+
+	o.OutputFormat = cmdutil.GetFlagString(cmd, "output")
+
+	o.Printer = func(obj runtime.Object, out io.Writer) error {
+		return cmdutil.PrintObject(cmd, obj, out)
+	}
+
+	return nil
+}
+
+func (o *CreateIdentityOptions) Validate() error {
+	if len(o.ProviderName) == 0 {
+		return fmt.Errorf("provider name is required")
+	}
+	if len(o.ProviderUserName) == 0 {
+		return fmt.Errorf("provider user name is required")
+	}
+	if !o.DryRun && o.IdentityClient == nil {
+		return fmt.Errorf("IdentityClient is required")
+	}
+	if o.Out == nil {
+		return fmt.Errorf("Out is required")
+	}
+	if o.Printer == nil {
+		return fmt.Errorf("Printer is required")
+	}
+
+	return nil
+}
+
+func (o *CreateIdentityOptions) Run() error {
+	identity := newIdentity(o.ProviderName, o.ProviderUserName, o.Extra)
+
+	actualIdentity := identity
+
+	var err error
+	if !o.DryRun {
+		actualIdentity, err = o.IdentityClient.Create(identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if useShortOutput := o.OutputFormat == "name"; useShortOutput || len(o.OutputFormat) == 0 {
+		cmdutil.PrintSuccess(useShortOutput, o.Out, actualIdentity, o.DryRun, "created")
+		return nil
+	}
+
+	return o.Printer(actualIdentity, o.Out)
+}
+
+func newIdentity(providerName, providerUserName string, extra map[string]string) *userapi.Identity {
+	identity := &userapi.Identity{}
+	identity.Name = identityName(providerName, providerUserName)
+	identity.ProviderName = providerName
+	identity.ProviderUserName = providerUserName
+	identity.Extra = extra
+	return identity
+}
+
+// parseExtraFlags turns a list of "key=value" pairs from repeated --extra flags into a map.
+func parseExtraFlags(extra []string) (map[string]string, error) {
+	if len(extra) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(extra))
+	for _, pair := range extra {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("--extra must be of the form key=value, not %q", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
@@ -0,0 +1,162 @@
+package create
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
+)
+
+func TestIdentityName(t *testing.T) {
+	if got, want := identityName("ldap", "adamjones"), "ldap:adamjones"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewIdentity(t *testing.T) {
+	identity := newIdentity("ldap", "adamjones", map[string]string{"email": "adam@example.com"})
+
+	if got, want := identity.Name, "ldap:adamjones"; got != want {
+		t.Fatalf("got name %q, want %q", got, want)
+	}
+	if got, want := identity.ProviderName, "ldap"; got != want {
+		t.Fatalf("got provider name %q, want %q", got, want)
+	}
+	if got, want := identity.ProviderUserName, "adamjones"; got != want {
+		t.Fatalf("got provider user name %q, want %q", got, want)
+	}
+	if got, want := identity.Extra, map[string]string{"email": "adam@example.com"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got extra %#v, want %#v", got, want)
+	}
+}
+
+func TestParseExtraFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "no flags returns a nil map",
+			extra: nil,
+			want:  nil,
+		},
+		{
+			name:  "parses one key=value pair",
+			extra: []string{"email=adam@example.com"},
+			want:  map[string]string{"email": "adam@example.com"},
+		},
+		{
+			name:  "parses multiple pairs",
+			extra: []string{"email=adam@example.com", "name=Adam Jones"},
+			want:  map[string]string{"email": "adam@example.com", "name": "Adam Jones"},
+		},
+		{
+			name:  "value may itself contain an equals sign",
+			extra: []string{"token=a=b=c"},
+			want:  map[string]string{"token": "a=b=c"},
+		},
+		{
+			name:    "missing equals sign is an error",
+			extra:   []string{"email"},
+			wantErr: true,
+		},
+		{
+			name:    "empty key is an error",
+			extra:   []string{"=value"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExtraFlags(tt.extra)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateIdentityOptionsComplete(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		wantProviderName string
+		wantProviderUser string
+		wantErr          bool
+	}{
+		{
+			name:             "splits provider and provider user name on the first colon",
+			args:             []string{"ldap:adamjones"},
+			wantProviderName: "ldap",
+			wantProviderUser: "adamjones",
+		},
+		{
+			name:    "no arguments is an error",
+			args:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "missing colon is an error",
+			args:    []string{"no-colon-here"},
+			wantErr: true,
+		},
+		{
+			name:    "missing provider name is an error",
+			args:    []string{":adamjones"},
+			wantErr: true,
+		},
+		{
+			name:    "missing provider user name is an error",
+			args:    []string{"ldap:"},
+			wantErr: true,
+		},
+		{
+			name:    "more than one argument is an error",
+			args:    []string{"ldap:adamjones", "extra"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmdutil.AddPrinterFlags(cmd)
+			cmdutil.AddDryRunFlag(cmd)
+			if err := cmd.Flags().Set("dry-run", "true"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			o := &CreateIdentityOptions{}
+			err := o.Complete(cmd, nil, tt.args, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if o.ProviderName != tt.wantProviderName {
+				t.Fatalf("got provider name %q, want %q", o.ProviderName, tt.wantProviderName)
+			}
+			if o.ProviderUserName != tt.wantProviderUser {
+				t.Fatalf("got provider user name %q, want %q", o.ProviderUserName, tt.wantProviderUser)
+			}
+		})
+	}
+}
@@ -1,13 +1,21 @@
 package create
 
 import (
+	"encoding/csv"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"strings"
 
+	"github.com/ghodss/yaml"
 	"github.com/spf13/cobra"
 
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	kapi "k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/kubectl"
 	"k8s.io/kubernetes/pkg/kubectl/cmd/templates"
 	cmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
 	kcmdutil "k8s.io/kubernetes/pkg/kubectl/cmd/util"
@@ -24,18 +32,79 @@ var (
 		Typically, identities are automatically mapped to users during login. If automatic
 		mapping is disabled (by using the "lookup" mapping method), or a mapping needs to
 		be manually established between an identity and a user, this command can be used
-		to create a useridentitymapping object.`)
+		to create a useridentitymapping object.
+
+		Instead of a single identity and user, a batch of mappings can be created with
+		--from-file, which accepts a CSV or YAML file of identity/user pairs such as the
+		output of an LDAP sync job.
+
+		Creating or reconciling a mapping checks whether the referenced user exists before
+		creating it (and, with --create-identity, does the same for the identity), so the
+		caller now additionally needs permission to get users (and identities). Previously
+		only permission to create useridentitymappings was required, with the server
+		rejecting a reference to a missing user.`)
 
 	userIdentityMappingExample = templates.Examples(`
 		# Map the identity "acme_ldap:adamjones" to the user "ajones"
-  	%[1]s acme_ldap:adamjones ajones`)
+  	%[1]s acme_ldap:adamjones ajones
+
+		# Create mappings for every row of a CSV file exported from an LDAP sync job
+  	%[1]s --from-file=ldap-sync.csv`)
+)
+
+// userIdentityMappingRow is a single identity/user pair parsed out of a --from-file input.
+type userIdentityMappingRow struct {
+	Identity string `json:"identity"`
+	User     string `json:"user"`
+}
+
+// Valid values for --if-user-missing.
+const (
+	IfUserMissingCreate = "create"
+	IfUserMissingError  = "error"
+	IfUserMissingSkip   = "skip"
 )
 
+// UserIdentityMappingGeneratorV1 builds a UserIdentityMapping from an identity/user pair
+// without touching the API server, so that callers can generate a manifest offline with
+// `--dry-run -o yaml` for later `oc apply`.
+type UserIdentityMappingGeneratorV1 struct {
+	Identity string
+	User     string
+}
+
+var _ kubectl.StructuredGenerator = UserIdentityMappingGeneratorV1{}
+
+func (g UserIdentityMappingGeneratorV1) StructuredGenerate() (runtime.Object, error) {
+	if len(g.Identity) == 0 {
+		return nil, fmt.Errorf("identity must be specified")
+	}
+	if len(g.User) == 0 {
+		return nil, fmt.Errorf("user must be specified")
+	}
+
+	mapping := &userapi.UserIdentityMapping{}
+	mapping.Identity = kapi.ObjectReference{Name: g.Identity}
+	mapping.User = kapi.ObjectReference{Name: g.User}
+	return mapping, nil
+}
+
 type CreateUserIdentityMappingOptions struct {
 	User     string
 	Identity string
 
+	FromFile    string
+	Overwrite   bool
+	NormalizeDN bool
+
+	ForceConflicts bool
+	IfUserMissing  string
+
+	CreateIdentity bool
+
 	UserIdentityMappingClient userclient.UserIdentityMappingInterface
+	UserClient                userclient.UserInterface
+	IdentityClient            userclient.IdentityInterface
 
 	DryRun bool
 
@@ -61,33 +130,54 @@ func NewCmdCreateUserIdentityMapping(name, fullName string, f kcmdutil.Factory,
 	}
 	cmdutil.AddPrinterFlags(cmd)
 	cmdutil.AddDryRunFlag(cmd)
+	cmd.Flags().StringVar(&o.FromFile, "from-file", "", "Create mappings in bulk from a CSV or YAML file of identity,user pairs, such as the output of an LDAP sync job")
+	cmd.Flags().BoolVar(&o.Overwrite, "overwrite", false, "If true, delete and recreate any mapping that already exists instead of reconciling it against the requested user")
+	cmd.Flags().BoolVar(&o.NormalizeDN, "normalize-dn", false, "With --from-file, lowercase and re-escape the DN portion of provider:dn identities so CSV exports from LDAP tools match existing Identity objects")
+	cmd.Flags().BoolVar(&o.ForceConflicts, "force-conflicts", false, "If true, update a mapping that already points to a different user instead of failing")
+	cmd.Flags().StringVar(&o.IfUserMissing, "if-user-missing", IfUserMissingError, "What to do when the referenced user does not exist: create, error, or skip")
+	cmd.Flags().BoolVar(&o.CreateIdentity, "create-identity", false, "If true, create the identity first if it does not already exist, then map it to the user")
 	return cmd
 }
 
 func (o *CreateUserIdentityMappingOptions) Complete(cmd *cobra.Command, f kcmdutil.Factory, args []string) error {
-	switch len(args) {
-	case 0:
-		return fmt.Errorf("identity is required")
-	case 1:
-		return fmt.Errorf("user name is required")
-	case 2:
-		o.Identity = args[0]
-		o.User = args[1]
-	default:
-		return fmt.Errorf("exactly two arguments (identity and user name) are supported, not: %v", args)
+	o.FromFile = cmdutil.GetFlagString(cmd, "from-file")
+
+	if len(o.FromFile) > 0 {
+		if len(args) > 0 {
+			return fmt.Errorf("identity and user name arguments may not be combined with --from-file")
+		}
+	} else {
+		switch len(args) {
+		case 0:
+			return fmt.Errorf("identity is required")
+		case 1:
+			return fmt.Errorf("user name is required")
+		case 2:
+			o.Identity = args[0]
+			o.User = args[1]
+		default:
+			return fmt.Errorf("exactly two arguments (identity and user name) are supported, not: %v", args)
+		}
 	}
 
 	o.DryRun = cmdutil.GetFlagBool(cmd, "dry-run")
 
-	clientConfig, err := f.ToRESTConfig()
-	if err != nil {
-		return err
-	}
-	client, err := userclientinternal.NewForConfig(clientConfig)
-	if err != nil {
-		return err
+	// A dry run never touches the API server (see createMapping), so avoid building a
+	// client at all: this lets `--dry-run -o yaml` generate a manifest with no cluster
+	// connection, for use in CI pipelines ahead of a later `oc apply`.
+	if !o.DryRun {
+		clientConfig, err := f.ToRESTConfig()
+		if err != nil {
+			return err
+		}
+		client, err := userclientinternal.NewForConfig(clientConfig)
+		if err != nil {
+			return err
+		}
+		o.UserIdentityMappingClient = client.User().UserIdentityMappings()
+		o.UserClient = client.User().Users()
+		o.IdentityClient = client.User().Identities()
 	}
-	o.UserIdentityMappingClient = client.User().UserIdentityMappings()
 
 	o.OutputFormat = cmdutil.GetFlagString(cmd, "output")
 
@@ -99,14 +189,33 @@ func (o *CreateUserIdentityMappingOptions) Complete(cmd *cobra.Command, f kcmdut
 }
 
 func (o *CreateUserIdentityMappingOptions) Validate() error {
-	if len(o.Identity) == 0 {
-		return fmt.Errorf("identity is required")
+	if len(o.FromFile) == 0 {
+		if len(o.Identity) == 0 {
+			return fmt.Errorf("identity is required")
+		}
+		if len(o.User) == 0 {
+			return fmt.Errorf("user is required")
+		}
+	} else {
+		if len(o.Identity) > 0 || len(o.User) > 0 {
+			return fmt.Errorf("identity and user name arguments may not be combined with --from-file")
+		}
 	}
-	if len(o.User) == 0 {
-		return fmt.Errorf("user is required")
+	switch o.IfUserMissing {
+	case IfUserMissingCreate, IfUserMissingError, IfUserMissingSkip:
+	default:
+		return fmt.Errorf("--if-user-missing must be one of: %s, %s, %s", IfUserMissingCreate, IfUserMissingError, IfUserMissingSkip)
 	}
-	if o.UserIdentityMappingClient == nil {
-		return fmt.Errorf("UserIdentityMappingClient is required")
+	if !o.DryRun {
+		if o.UserIdentityMappingClient == nil {
+			return fmt.Errorf("UserIdentityMappingClient is required")
+		}
+		if o.UserClient == nil {
+			return fmt.Errorf("UserClient is required")
+		}
+		if o.CreateIdentity && o.IdentityClient == nil {
+			return fmt.Errorf("IdentityClient is required")
+		}
 	}
 	if o.Out == nil {
 		return fmt.Errorf("Out is required")
@@ -118,25 +227,335 @@ func (o *CreateUserIdentityMappingOptions) Validate() error {
 	return nil
 }
 
+// mappingOutcome records what createMapping actually did with a row, so callers can
+// report an accurate summary instead of inferring it from whether an error came back.
+type mappingOutcome int
+
+const (
+	mappingCreated mappingOutcome = iota
+	mappingUpdated
+	mappingUnchanged
+	mappingSkippedUserMissing
+)
+
 func (o *CreateUserIdentityMappingOptions) Run() error {
-	mapping := &userapi.UserIdentityMapping{}
-	mapping.Identity = kapi.ObjectReference{Name: o.Identity}
-	mapping.User = kapi.ObjectReference{Name: o.User}
+	if len(o.FromFile) > 0 {
+		return o.runFromFile()
+	}
+	outcome, mapping, err := o.createMapping(o.Identity, o.User)
+	if err != nil {
+		return err
+	}
+	if outcome == mappingSkippedUserMissing {
+		return nil
+	}
+	return o.printMapping(mapping, outcome)
+}
+
+// runFromFile loads identity/user rows from --from-file and creates a mapping for each,
+// continuing past per-row failures so that one bad row doesn't abort the whole batch.
+//
+// For the default/"name" output, each row is printed as it's processed. For any other
+// (structured) output format, rows are instead collected and printed once as a single
+// list at the end: printing each row through its own Printer call has no way to separate
+// documents, so e.g. --dry-run -o yaml would otherwise produce concatenated YAML with no
+// "---" document separators, which doesn't round-trip through `oc apply -f -`.
+func (o *CreateUserIdentityMappingOptions) runFromFile() error {
+	rows, err := loadUserIdentityMappingRows(o.FromFile)
+	if err != nil {
+		return err
+	}
 
-	actualMapping := mapping
+	batchOutput := len(o.OutputFormat) > 0 && o.OutputFormat != "name"
 
-	var err error
-	if !o.DryRun {
-		actualMapping, err = o.UserIdentityMappingClient.Create(mapping)
+	var created, updated, unchanged, skipped int
+	var batch []runtime.Object
+	var errs []error
+	for _, row := range rows {
+		identity := row.Identity
+		if o.NormalizeDN {
+			identity, err = normalizeIdentityDN(identity)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s,%s: %v", row.Identity, row.User, err))
+				continue
+			}
+		}
+
+		outcome, mapping, err := o.createMapping(identity, row.User)
 		if err != nil {
-			return err
+			errs = append(errs, fmt.Errorf("%s,%s: %v", identity, row.User, err))
+			continue
+		}
+		if outcome == mappingSkippedUserMissing {
+			skipped++
+			continue
+		}
+
+		if batchOutput {
+			batch = append(batch, mapping)
+		} else if err := o.printMapping(mapping, outcome); err != nil {
+			errs = append(errs, fmt.Errorf("%s,%s: %v", identity, row.User, err))
+			continue
+		}
+
+		switch outcome {
+		case mappingUnchanged:
+			unchanged++
+		case mappingUpdated:
+			updated++
+		default:
+			created++
+		}
+	}
+
+	if batchOutput && len(batch) > 0 {
+		if err := o.Printer(&kapi.List{Items: batch}, o.Out); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
+	// "unchanged" reflects reconcileExisting reporting a row that already mapped to the
+	// requested user (a no-op), and "updated" a row it had to overwrite or force-update;
+	// neither is distinguishable from a plain created/failed count, nor is a row skipped
+	// outright for --if-user-missing=skip.
+	fmt.Fprintf(o.Out, "Summary: %d created, %d updated, %d unchanged, %d skipped (user missing), %d failed, %d total\n", created, updated, unchanged, skipped, len(errs), len(rows))
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// createMapping builds (and, unless --dry-run, reconciles per --overwrite/--force-conflicts)
+// a single identity/user mapping. It reports what happened as a mappingOutcome rather than
+// just success/failure, so batch callers can tell a fresh create apart from a no-op
+// reconciliation or a mapping skipped outright for --if-user-missing=skip, instead of
+// folding every non-error result into "created".
+func (o *CreateUserIdentityMappingOptions) createMapping(identity, user string) (mappingOutcome, *userapi.UserIdentityMapping, error) {
+	obj, err := (UserIdentityMappingGeneratorV1{Identity: identity, User: user}).StructuredGenerate()
+	if err != nil {
+		return mappingCreated, nil, err
+	}
+	mapping := obj.(*userapi.UserIdentityMapping)
+
+	if o.DryRun {
+		return mappingCreated, mapping, nil
+	}
+
+	ok, err := o.ensureUser(user)
+	if err != nil {
+		return mappingCreated, nil, err
+	}
+	if !ok {
+		return mappingSkippedUserMissing, nil, nil
+	}
+
+	if o.CreateIdentity {
+		if err := o.ensureIdentity(identity); err != nil {
+			return mappingCreated, nil, err
+		}
+	}
+
+	actualMapping, err := o.UserIdentityMappingClient.Create(mapping)
+	outcome := mappingCreated
+	if kapierrors.IsAlreadyExists(err) {
+		actualMapping, outcome, err = o.reconcileExisting(identity, user, mapping)
+	}
+	if err != nil {
+		return mappingCreated, nil, err
+	}
+
+	return outcome, actualMapping, nil
+}
+
+// mappingOutcomeVerb is the past-tense operation passed to cmdutil.PrintSuccess for the
+// default/"name" output, so a reconciled no-op or forced update doesn't get reported as
+// "created".
+func mappingOutcomeVerb(outcome mappingOutcome) string {
+	switch outcome {
+	case mappingUpdated:
+		return "updated"
+	case mappingUnchanged:
+		return "unchanged"
+	default:
+		return "created"
+	}
+}
+
+// printMapping prints a single mapping: a short success line for the default/"name"
+// output, or the full object through the requested structured printer otherwise.
+func (o *CreateUserIdentityMappingOptions) printMapping(mapping *userapi.UserIdentityMapping, outcome mappingOutcome) error {
 	if useShortOutput := o.OutputFormat == "name"; useShortOutput || len(o.OutputFormat) == 0 {
-		cmdutil.PrintSuccess(useShortOutput, o.Out, actualMapping, o.DryRun, "created")
+		cmdutil.PrintSuccess(useShortOutput, o.Out, mapping, o.DryRun, mappingOutcomeVerb(outcome))
 		return nil
 	}
+	return o.Printer(mapping, o.Out)
+}
+
+// reconcileExisting handles a Create that failed with AlreadyExists: with --overwrite it
+// deletes and recreates the mapping unconditionally; otherwise it fetches the existing
+// mapping and, if its User differs from the requested one, either updates it in place
+// (--force-conflicts) or reports the conflict. It reports mappingUnchanged when the
+// existing mapping already pointed at the requested user and mappingUpdated when it had
+// to overwrite or force an update, so callers (and their printed output) don't have to
+// infer what happened from the mere absence of an error.
+func (o *CreateUserIdentityMappingOptions) reconcileExisting(identity, user string, mapping *userapi.UserIdentityMapping) (*userapi.UserIdentityMapping, mappingOutcome, error) {
+	if o.Overwrite {
+		if err := o.UserIdentityMappingClient.Delete(identity, nil); err != nil {
+			return nil, mappingCreated, err
+		}
+		created, err := o.UserIdentityMappingClient.Create(mapping)
+		return created, mappingUpdated, err
+	}
+
+	existing, err := o.UserIdentityMappingClient.Get(identity, metav1.GetOptions{})
+	if err != nil {
+		return nil, mappingCreated, err
+	}
+	if existing.User.Name == user {
+		return existing, mappingUnchanged, nil
+	}
+	if !o.ForceConflicts {
+		return nil, mappingCreated, fmt.Errorf("useridentitymapping %q already maps to user %q; use --force-conflicts to update it to %q", identity, existing.User.Name, user)
+	}
+
+	existing.User = kapi.ObjectReference{Name: user}
+	updated, err := o.UserIdentityMappingClient.Update(existing)
+	return updated, mappingUpdated, err
+}
+
+// ensureIdentity creates the Identity referenced by a mapping if it does not already
+// exist, for use with --create-identity. The identity must be in "provider:dn" form so
+// that its ProviderName/ProviderUserName can be recovered.
+func (o *CreateUserIdentityMappingOptions) ensureIdentity(identity string) error {
+	if _, err := o.IdentityClient.Get(identity, metav1.GetOptions{}); err == nil {
+		return nil
+	} else if !kapierrors.IsNotFound(err) {
+		return err
+	}
+
+	parts := strings.SplitN(identity, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected identity in the form provider:providerUserName, got %q", identity)
+	}
 
-	return o.Printer(actualMapping, o.Out)
+	_, err := o.IdentityClient.Create(newIdentity(parts[0], parts[1], nil))
+	if err != nil && !kapierrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// ensureUser checks that the User referenced by a mapping exists, applying
+// --if-user-missing when it does not. It returns false when the caller should
+// skip creating the mapping entirely (--if-user-missing=skip).
+func (o *CreateUserIdentityMappingOptions) ensureUser(user string) (bool, error) {
+	_, err := o.UserClient.Get(user, metav1.GetOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if !kapierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	switch o.IfUserMissing {
+	case IfUserMissingCreate:
+		if _, err := o.UserClient.Create(&userapi.User{ObjectMeta: metav1.ObjectMeta{Name: user}}); err != nil && !kapierrors.IsAlreadyExists(err) {
+			return false, err
+		}
+		return true, nil
+	case IfUserMissingSkip:
+		fmt.Fprintf(o.Out, "user %q does not exist, skipping mapping\n", user)
+		return false, nil
+	default:
+		return false, fmt.Errorf("user %q does not exist", user)
+	}
+}
+
+// loadUserIdentityMappingRows parses a --from-file input as a YAML list of
+// {identity, user} objects, falling back to a CSV of "identity,user" lines.
+// Blank lines and lines beginning with "#" are ignored in the CSV form.
+func loadUserIdentityMappingRows(path string) ([]userIdentityMappingRow, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+
+	if rows, ok := tryParseYAMLRows(data); ok {
+		return rows, nil
+	}
+
+	// A DN identity (e.g. "ldap:CN=Jane Doe,OU=People,DC=Example,DC=COM") contains
+	// RDN-separating commas by construction, so this can't just split each line on its
+	// first comma: that truncates the identity at its first RDN and stuffs the rest of
+	// the DN into the user field. Use a real CSV reader so a quoted identity field
+	// round-trips correctly, and for an unquoted DN (which the reader itself will split
+	// into more than 2 fields) treat every field but the last as part of the identity,
+	// since the user name is always the last column.
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	var rows []userIdentityMappingRow
+	for lineNum := 1; ; lineNum++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"identity,user\", got %q", path, lineNum, strings.Join(record, ","))
+		}
+		identity := strings.TrimSpace(strings.Join(record[:len(record)-1], ","))
+		user := strings.TrimSpace(record[len(record)-1])
+		rows = append(rows, userIdentityMappingRow{Identity: identity, User: user})
+	}
+
+	return rows, nil
+}
+
+func tryParseYAMLRows(data []byte) ([]userIdentityMappingRow, bool) {
+	var rows []userIdentityMappingRow
+	if err := yaml.Unmarshal(data, &rows); err != nil {
+		return nil, false
+	}
+	for _, row := range rows {
+		if len(row.Identity) == 0 || len(row.User) == 0 {
+			return nil, false
+		}
+	}
+	return rows, len(rows) > 0
+}
+
+// normalizeIdentityDN lowercases and re-escapes the DN portion of a "provider:dn" identity
+// name, so that CSV exports from LDAP tools consistently match existing Identity objects
+// regardless of the case or escaping conventions used by the exporting tool.
+func normalizeIdentityDN(identity string) (string, error) {
+	parts := strings.SplitN(identity, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("expected identity in the form provider:providerUserName, got %q", identity)
+	}
+	provider, dn := parts[0], parts[1]
+	return provider + ":" + escapeDN(strings.ToLower(dn)), nil
+}
+
+// escapeDN re-escapes the RFC 4514 special characters in a DN that may have been
+// unescaped by a CSV export, without double-escaping characters already escaped.
+func escapeDN(dn string) string {
+	var buf strings.Builder
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch c {
+		case '\\':
+			if i+1 < len(dn) {
+				buf.WriteByte(c)
+				buf.WriteByte(dn[i+1])
+				i++
+				continue
+			}
+		case ',', '+', '"', '<', '>', ';':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
 }
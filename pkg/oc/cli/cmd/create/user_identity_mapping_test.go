@@ -0,0 +1,299 @@
+package create
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	kapierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kapi "k8s.io/kubernetes/pkg/apis/core"
+
+	userapi "github.com/openshift/origin/pkg/user/apis/user"
+)
+
+var userIdentityMappingResource = schema.GroupResource{Group: "user.openshift.io", Resource: "useridentitymappings"}
+
+// fakeUserIdentityMappingClient is a minimal in-memory stand-in for
+// userclient.UserIdentityMappingInterface, covering only the Get/Create/Update/Delete
+// methods reconcileExisting actually uses.
+type fakeUserIdentityMappingClient struct {
+	mappings map[string]*userapi.UserIdentityMapping
+	deleted  []string
+}
+
+func (f *fakeUserIdentityMappingClient) Create(m *userapi.UserIdentityMapping) (*userapi.UserIdentityMapping, error) {
+	if f.mappings == nil {
+		f.mappings = map[string]*userapi.UserIdentityMapping{}
+	}
+	if _, exists := f.mappings[m.Identity.Name]; exists {
+		return nil, kapierrors.NewAlreadyExists(userIdentityMappingResource, m.Identity.Name)
+	}
+	f.mappings[m.Identity.Name] = m
+	return m, nil
+}
+
+func (f *fakeUserIdentityMappingClient) Update(m *userapi.UserIdentityMapping) (*userapi.UserIdentityMapping, error) {
+	f.mappings[m.Identity.Name] = m
+	return m, nil
+}
+
+func (f *fakeUserIdentityMappingClient) Delete(name string, options *metav1.DeleteOptions) error {
+	f.deleted = append(f.deleted, name)
+	delete(f.mappings, name)
+	return nil
+}
+
+func (f *fakeUserIdentityMappingClient) Get(name string, options metav1.GetOptions) (*userapi.UserIdentityMapping, error) {
+	m, ok := f.mappings[name]
+	if !ok {
+		return nil, kapierrors.NewNotFound(userIdentityMappingResource, name)
+	}
+	return m, nil
+}
+
+func writeTempFile(t *testing.T, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "useridentitymapping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "mappings")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestLoadUserIdentityMappingRowsCSV(t *testing.T) {
+	path := writeTempFile(t, "# synced from LDAP\nidentity1,user1\n\nldap:adamjones,user2\n")
+
+	rows, err := loadUserIdentityMappingRows(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []userIdentityMappingRow{
+		{Identity: "identity1", User: "user1"},
+		{Identity: "ldap:adamjones", User: "user2"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("got %#v, want %#v", rows, expected)
+	}
+}
+
+func TestLoadUserIdentityMappingRowsCSVEmbeddedCommaDN(t *testing.T) {
+	path := writeTempFile(t, "ldap:CN=Jane Doe,OU=People,DC=Example,DC=COM,jdoe\n")
+
+	rows, err := loadUserIdentityMappingRows(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []userIdentityMappingRow{
+		{Identity: "ldap:CN=Jane Doe,OU=People,DC=Example,DC=COM", User: "jdoe"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("got %#v, want %#v", rows, expected)
+	}
+}
+
+func TestLoadUserIdentityMappingRowsCSVQuotedDN(t *testing.T) {
+	path := writeTempFile(t, `"ldap:CN=Jane Doe,OU=People,DC=Example,DC=COM",jdoe`+"\n")
+
+	rows, err := loadUserIdentityMappingRows(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []userIdentityMappingRow{
+		{Identity: "ldap:CN=Jane Doe,OU=People,DC=Example,DC=COM", User: "jdoe"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("got %#v, want %#v", rows, expected)
+	}
+}
+
+func TestLoadUserIdentityMappingRowsCSVBadLine(t *testing.T) {
+	path := writeTempFile(t, "not-a-valid-line\n")
+
+	if _, err := loadUserIdentityMappingRows(path); err == nil {
+		t.Fatal("expected an error for a line without an identity,user pair")
+	}
+}
+
+func TestLoadUserIdentityMappingRowsYAML(t *testing.T) {
+	path := writeTempFile(t, "- identity: identity1\n  user: user1\n- identity: identity2\n  user: user2\n")
+
+	rows, err := loadUserIdentityMappingRows(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []userIdentityMappingRow{
+		{Identity: "identity1", User: "user1"},
+		{Identity: "identity2", User: "user2"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("got %#v, want %#v", rows, expected)
+	}
+}
+
+func TestNormalizeIdentityDN(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "lowercases the DN and re-escapes its RDN-separating commas",
+			identity: "LDAP:CN=Jane Doe,OU=People,DC=Example,DC=COM",
+			want:     "LDAP:cn=jane doe\\,ou=people\\,dc=example\\,dc=com",
+		},
+		{
+			name:     "escapes an unescaped comma without double-escaping one that's already escaped",
+			identity: "ldap:cn=doe\\, jane,ou=people",
+			want:     "ldap:cn=doe\\, jane\\,ou=people",
+		},
+		{
+			name:     "does not escape the attribute/value separator",
+			identity: "ldap:cn=jane,ou=people",
+			want:     "ldap:cn=jane\\,ou=people",
+		},
+		{
+			name:     "missing provider prefix is an error",
+			identity: "no-colon-here",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeIdentityDN(tt.identity)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func newMapping(identity, user string) *userapi.UserIdentityMapping {
+	m := &userapi.UserIdentityMapping{}
+	m.Identity = kapi.ObjectReference{Name: identity}
+	m.User = kapi.ObjectReference{Name: user}
+	return m
+}
+
+func TestReconcileExisting(t *testing.T) {
+	t.Run("overwrite deletes and recreates unconditionally", func(t *testing.T) {
+		client := &fakeUserIdentityMappingClient{mappings: map[string]*userapi.UserIdentityMapping{
+			"identity": newMapping("identity", "olduser"),
+		}}
+		o := &CreateUserIdentityMappingOptions{Overwrite: true, UserIdentityMappingClient: client}
+
+		got, outcome, err := o.reconcileExisting("identity", "newuser", newMapping("identity", "newuser"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if outcome != mappingUpdated {
+			t.Fatalf("got outcome %v, want mappingUpdated", outcome)
+		}
+		if got.User.Name != "newuser" {
+			t.Fatalf("got user %q, want %q", got.User.Name, "newuser")
+		}
+		if len(client.deleted) != 1 || client.deleted[0] != "identity" {
+			t.Fatalf("expected the existing mapping to be deleted, got %v", client.deleted)
+		}
+	})
+
+	t.Run("already mapped to the requested user is a no-op", func(t *testing.T) {
+		client := &fakeUserIdentityMappingClient{mappings: map[string]*userapi.UserIdentityMapping{
+			"identity": newMapping("identity", "sameuser"),
+		}}
+		o := &CreateUserIdentityMappingOptions{UserIdentityMappingClient: client}
+
+		got, outcome, err := o.reconcileExisting("identity", "sameuser", newMapping("identity", "sameuser"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if outcome != mappingUnchanged {
+			t.Fatalf("got outcome %v, want mappingUnchanged", outcome)
+		}
+		if got.User.Name != "sameuser" {
+			t.Fatalf("got user %q, want %q", got.User.Name, "sameuser")
+		}
+		if len(client.deleted) != 0 {
+			t.Fatalf("expected no deletes, got %v", client.deleted)
+		}
+	})
+
+	t.Run("conflicting user without --force-conflicts is an error", func(t *testing.T) {
+		client := &fakeUserIdentityMappingClient{mappings: map[string]*userapi.UserIdentityMapping{
+			"identity": newMapping("identity", "olduser"),
+		}}
+		o := &CreateUserIdentityMappingOptions{UserIdentityMappingClient: client}
+
+		if _, _, err := o.reconcileExisting("identity", "newuser", newMapping("identity", "newuser")); err == nil {
+			t.Fatal("expected a conflict error")
+		}
+		if client.mappings["identity"].User.Name != "olduser" {
+			t.Fatalf("existing mapping should be unchanged, got user %q", client.mappings["identity"].User.Name)
+		}
+	})
+
+	t.Run("conflicting user with --force-conflicts updates in place", func(t *testing.T) {
+		client := &fakeUserIdentityMappingClient{mappings: map[string]*userapi.UserIdentityMapping{
+			"identity": newMapping("identity", "olduser"),
+		}}
+		o := &CreateUserIdentityMappingOptions{ForceConflicts: true, UserIdentityMappingClient: client}
+
+		got, outcome, err := o.reconcileExisting("identity", "newuser", newMapping("identity", "newuser"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if outcome != mappingUpdated {
+			t.Fatalf("got outcome %v, want mappingUpdated", outcome)
+		}
+		if got.User.Name != "newuser" {
+			t.Fatalf("got user %q, want %q", got.User.Name, "newuser")
+		}
+		if len(client.deleted) != 0 {
+			t.Fatalf("expected an in-place update, not a delete, got %v", client.deleted)
+		}
+	})
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "escapes an unescaped comma", in: "a,b", want: "a\\,b"},
+		{name: "does not double-escape an already-escaped comma", in: "a\\,b", want: "a\\,b"},
+		{name: "escapes multiple special characters", in: `a+b"c;d`, want: `a\+b\"c\;d`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeDN(tt.in); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}